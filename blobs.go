@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ImageBlob records a piece of image content we've already downloaded and
+// compressed, keyed by the SHA-256 digest of its original bytes, so that
+// products referencing the same image don't pay for a second download or
+// compression pass.
+type ImageBlob struct {
+	Digest         string `gorm:"primaryKey" json:"digest"`
+	OriginalPath   string `json:"original_path"`
+	CompressedPath string `json:"compressed_path"`
+	Size           int64  `json:"size"`
+	MimeType       string `json:"mime_type"`
+	RefCount       int    `gorm:"default:1" json:"refcount"`
+}
+
+func findImageBlob(digest string) (ImageBlob, bool, error) {
+	var blob ImageBlob
+	err := db.First(&blob, "digest = ?", digest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ImageBlob{}, false, nil
+	}
+	if err != nil {
+		return ImageBlob{}, false, err
+	}
+	return blob, true, nil
+}
+
+// createImageBlob records a newly stored blob, or, if a concurrent caller
+// resolving the same digest for the first time has already inserted it,
+// bumps that row's refcount instead of failing on the digest primary-key
+// conflict. Without the upsert, the loser of that race would have its
+// Create rejected and the error swallowed by the caller, leaving the
+// blob's refcount permanently short by one reference.
+func createImageBlob(blob ImageBlob) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "digest"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("image_blobs.ref_count + 1")}),
+	}).Create(&blob).Error
+}
+
+func incrementImageBlobRefcount(digest string) error {
+	return db.Model(&ImageBlob{}).Where("digest = ?", digest).
+		UpdateColumn("ref_count", gorm.Expr("ref_count + 1")).Error
+}
+
+// resolveImageBlob decides whether digest's bytes have already been stored:
+// if a blob is already recorded, its existing paths are reused and its
+// refcount bumped; otherwise store is called to upload/compress the bytes
+// (the only part that differs between the URL-fetch and upload paths) and
+// the result is recorded via createImageBlob's create-or-increment upsert.
+// Being the single place both paths make this decision means the
+// first-resolution race only has to be gotten right once.
+func resolveImageBlob(digest string, size int64, contentType string, store func() (originalKey, compressedKey string, err error)) (string, string, error) {
+	return resolveImageBlobWith(digest, size, contentType, store, findImageBlob, incrementImageBlobRefcount, createImageBlob)
+}
+
+// resolveImageBlobWith is resolveImageBlob with its database operations
+// injected, so the concurrent-first-resolution race — two callers both
+// missing find() for a brand-new digest and both proceeding to store and
+// record it — can be unit tested against a fake store instead of a real
+// database.
+func resolveImageBlobWith(digest string, size int64, contentType string, store func() (string, string, error), find func(string) (ImageBlob, bool, error), increment func(string) error, create func(ImageBlob) error) (string, string, error) {
+	if blob, found, err := find(digest); err != nil {
+		return "", "", err
+	} else if found {
+		if err := increment(digest); err != nil {
+			logger.WithFields(logrus.Fields{
+				"digest": digest,
+				"error":  err,
+			}).Warn("Failed to bump refcount on existing image blob")
+		}
+		return blob.OriginalPath, blob.CompressedPath, nil
+	}
+
+	originalKey, compressedKey, err := store()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := create(ImageBlob{
+		Digest:         digest,
+		OriginalPath:   originalKey,
+		CompressedPath: compressedKey,
+		Size:           size,
+		MimeType:       contentType,
+		RefCount:       1,
+	}); err != nil {
+		logger.WithFields(logrus.Fields{
+			"digest": digest,
+			"error":  err,
+		}).Warn("Failed to record image blob")
+	}
+
+	return originalKey, compressedKey, nil
+}
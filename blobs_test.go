@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeBlobStore is an in-memory stand-in for the image_blobs table. find and
+// create/increment each take the lock independently (rather than the whole
+// resolveImageBlobWith call being serialized), so concurrent callers can
+// genuinely race each other's find() the way they would against Postgres.
+type fakeBlobStore struct {
+	mu    sync.Mutex
+	blobs map[string]ImageBlob
+}
+
+func (s *fakeBlobStore) find(digest string) (ImageBlob, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob, ok := s.blobs[digest]
+	return blob, ok, nil
+}
+
+func (s *fakeBlobStore) increment(digest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob := s.blobs[digest]
+	blob.RefCount++
+	s.blobs[digest] = blob
+	return nil
+}
+
+// create models Postgres's INSERT ... ON CONFLICT (digest) DO UPDATE SET
+// ref_count = ref_count + 1: a digest that's already present is bumped
+// instead of rejected.
+func (s *fakeBlobStore) create(blob ImageBlob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.blobs[blob.Digest]; ok {
+		existing.RefCount++
+		s.blobs[blob.Digest] = existing
+		return nil
+	}
+	s.blobs[blob.Digest] = blob
+	return nil
+}
+
+// TestResolveImageBlobConcurrentFirstResolutionConvergesRefcount exercises
+// the cross-request race the upsert in createImageBlob is meant to close:
+// several callers resolving the same brand-new digest at once should all
+// have their reference counted, rather than one insert winning and the
+// rest's conflicting inserts being silently dropped.
+func TestResolveImageBlobConcurrentFirstResolutionConvergesRefcount(t *testing.T) {
+	store := &fakeBlobStore{blobs: map[string]ImageBlob{}}
+	const digest = "digest-under-test"
+	const concurrency = 8
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := resolveImageBlobWith(digest, 123, "image/jpeg", func() (string, string, error) {
+				return "images/" + digest + ".jpg", "compressed_images/" + digest + ".jpg", nil
+			}, store.find, store.increment, store.create)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	store.mu.Lock()
+	blob, ok := store.blobs[digest]
+	store.mu.Unlock()
+	if !ok {
+		t.Fatal("expected a blob to be recorded for the digest")
+	}
+	if blob.RefCount != concurrency {
+		t.Fatalf("expected refcount %d after %d concurrent first-time resolutions, got %d — a lost update means some resolvers' references were silently dropped",
+			concurrency, concurrency, blob.RefCount)
+	}
+}
+
+// TestResolveImageBlobReusesExistingBlobWithoutStoring verifies the found
+// path never calls store, so a known digest is never re-downloaded or
+// re-compressed.
+func TestResolveImageBlobReusesExistingBlobWithoutStoring(t *testing.T) {
+	store := &fakeBlobStore{blobs: map[string]ImageBlob{
+		"known": {Digest: "known", OriginalPath: "images/known.jpg", CompressedPath: "compressed_images/known.jpg", RefCount: 1},
+	}}
+
+	storeCalled := false
+	original, compressed, err := resolveImageBlobWith("known", 1, "image/jpeg", func() (string, string, error) {
+		storeCalled = true
+		return "", "", fmt.Errorf("store should not be called for a known digest")
+	}, store.find, store.increment, store.create)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if storeCalled {
+		t.Fatal("expected store not to be called for an already-known digest")
+	}
+	if original != "images/known.jpg" || compressed != "compressed_images/known.jpg" {
+		t.Fatalf("expected the existing blob's paths to be reused, got (%q, %q)", original, compressed)
+	}
+
+	store.mu.Lock()
+	refCount := store.blobs["known"].RefCount
+	store.mu.Unlock()
+	if refCount != 2 {
+		t.Fatalf("expected refcount to be bumped to 2, got %d", refCount)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const productCacheKeyPrefix = "product:"
+const productListCacheKeyPrefix = "product:list:"
+const productListInvalidationMessage = "product:list:*"
+const productInvalidationChannel = "product_invalidations"
+
+// productListKeysSet tracks the set of product list cache keys currently in
+// Redis, so evictProductListKeys can delete exactly those keys instead of
+// scanning the whole keyspace.
+const productListKeysSet = "product:list:keys"
+
+var redisClient *redis.Client
+var cacheTTL = 5 * time.Minute
+
+var (
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zocket_cache_hits_total",
+		Help: "Number of product cache hits.",
+	})
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zocket_cache_misses_total",
+		Help: "Number of product cache misses.",
+	})
+)
+
+// setupCache connects to Redis, applies CACHE_TTL_SECONDS if set, and starts
+// the subscriber that evicts keys invalidated by other API instances.
+func setupCache() *redis.Client {
+	client := connectCache()
+	go subscribeInvalidations(client)
+	return client
+}
+
+// setupCachePublisher connects to Redis without starting the invalidation
+// subscriber, for processes (the worker) that only need to publish
+// invalidations and never serve reads from the cache themselves.
+func setupCachePublisher() *redis.Client {
+	return connectCache()
+}
+
+func connectCache() *redis.Client {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	if ttlSeconds := os.Getenv("CACHE_TTL_SECONDS"); ttlSeconds != "" {
+		if secs, err := strconv.Atoi(ttlSeconds); err == nil {
+			cacheTTL = time.Duration(secs) * time.Second
+		}
+	}
+
+	redisClient = redis.NewClient(&redis.Options{Addr: addr})
+	if _, err := redisClient.Ping().Result(); err != nil {
+		logger.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	return redisClient
+}
+
+func productCacheKey(id uint) string {
+	return fmt.Sprintf("%s%d", productCacheKeyPrefix, id)
+}
+
+func productListCacheKey(rawQuery string) string {
+	return productListCacheKeyPrefix + rawQuery
+}
+
+// subscribeInvalidations listens for product cache keys published on
+// productInvalidationChannel and deletes each one, or evicts every cached
+// listing when productListInvalidationMessage arrives. Every
+// API instance runs this so a write on one node evicts the stale copy held
+// by all the others.
+func subscribeInvalidations(client *redis.Client) {
+	sub := client.Subscribe(productInvalidationChannel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		if msg.Payload == productListInvalidationMessage {
+			evictProductListKeys(client)
+			continue
+		}
+		if err := client.Del(msg.Payload).Err(); err != nil {
+			logger.WithFields(logrus.Fields{
+				"key":   msg.Payload,
+				"error": err,
+			}).Warn("Failed to evict cache key on invalidation")
+		}
+	}
+}
+
+// evictProductListKeys deletes every cached product listing. It reads the
+// key names from productListKeysSet instead of KEYS/SCAN over the whole
+// keyspace, since this runs on every product write and a keyspace scan would
+// contend with read traffic on the hot path.
+func evictProductListKeys(client *redis.Client) {
+	keys, err := client.SMembers(productListKeysSet).Result()
+	if err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Warn("Failed to list product list cache keys")
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := client.Del(append(keys, productListKeysSet)...).Err(); err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Warn("Failed to evict product list cache keys")
+	}
+}
+
+func invalidateProductCache(id uint) {
+	if redisClient == nil {
+		return
+	}
+	if err := redisClient.Publish(productInvalidationChannel, productCacheKey(id)).Err(); err != nil {
+		logger.WithFields(logrus.Fields{
+			"product_id": id,
+			"error":      err,
+		}).Warn("Failed to publish cache invalidation")
+	}
+	invalidateProductListCache()
+}
+
+func invalidateProductListCache() {
+	if redisClient == nil {
+		return
+	}
+	if err := redisClient.Publish(productInvalidationChannel, productListInvalidationMessage).Err(); err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Warn("Failed to publish list cache invalidation")
+	}
+}
+
+// getProductCached returns a product from Redis if present, recording a
+// cache hit or miss. A nil product with a nil error means the key was not
+// found.
+func getProductCached(id uint) (*Product, error) {
+	if redisClient == nil {
+		return nil, nil
+	}
+
+	payload, err := redisClient.Get(productCacheKey(id)).Bytes()
+	if err == redis.Nil {
+		cacheMisses.Inc()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var product Product
+	if err := json.Unmarshal(payload, &product); err != nil {
+		return nil, err
+	}
+
+	cacheHits.Inc()
+	return &product, nil
+}
+
+// getProductsCached returns a cached product listing for the given raw
+// query string, if present.
+func getProductsCached(rawQuery string) ([]Product, error) {
+	if redisClient == nil {
+		return nil, nil
+	}
+
+	payload, err := redisClient.Get(productListCacheKey(rawQuery)).Bytes()
+	if err == redis.Nil {
+		cacheMisses.Inc()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var products []Product
+	if err := json.Unmarshal(payload, &products); err != nil {
+		return nil, err
+	}
+
+	cacheHits.Inc()
+	return products, nil
+}
+
+func setProductsCached(rawQuery string, products []Product) {
+	if redisClient == nil {
+		return
+	}
+
+	payload, err := json.Marshal(products)
+	if err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Warn("Failed to marshal product list for cache")
+		return
+	}
+
+	key := productListCacheKey(rawQuery)
+	if err := redisClient.Set(key, payload, cacheTTL).Err(); err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Warn("Failed to write product list to cache")
+		return
+	}
+	if err := redisClient.SAdd(productListKeysSet, key).Err(); err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Warn("Failed to track product list cache key")
+	}
+}
+
+func setProductCached(product *Product) {
+	if redisClient == nil {
+		return
+	}
+
+	payload, err := json.Marshal(product)
+	if err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Warn("Failed to marshal product for cache")
+		return
+	}
+
+	if err := redisClient.Set(productCacheKey(product.ID), payload, cacheTTL).Err(); err != nil {
+		logger.WithFields(logrus.Fields{
+			"product_id": product.ID,
+			"error":      err,
+		}).Warn("Failed to write product to cache")
+	}
+}
+
+func metricsHandler() http.HandlerFunc {
+	return promhttp.Handler().ServeHTTP
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestResolveImageBatchDedupesByURL verifies that indices sharing the same
+// URL are resolved once and the result fanned back out to all of them,
+// rather than resolving (and bumping the underlying blob's refcount) once
+// per index.
+func TestResolveImageBatchDedupesByURL(t *testing.T) {
+	urls := []string{
+		"http://example.com/a.jpg",
+		"http://example.com/b.jpg",
+		"http://example.com/a.jpg",
+		"http://example.com/a.jpg",
+	}
+	indices := []int{0, 1, 2, 3}
+	original := make([]string, len(urls))
+	compressed := make([]string, len(urls))
+
+	var calls int32
+	var mu sync.Mutex
+	var seen []string
+
+	resolve := func(_ context.Context, url string) (string, string, error) {
+		atomic.AddInt32(&calls, 1)
+		mu.Lock()
+		seen = append(seen, url)
+		mu.Unlock()
+		return url + "-original", url + "-compressed", nil
+	}
+
+	failed, err := resolveImageBatchWith(context.Background(), urls, indices, original, compressed, resolve)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Fatalf("expected no failures, got %v", failed)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected the resolver to be called once per unique URL (2), got %d calls for %v", got, seen)
+	}
+
+	for i, url := range urls {
+		wantOriginal := url + "-original"
+		wantCompressed := url + "-compressed"
+		if original[i] != wantOriginal || compressed[i] != wantCompressed {
+			t.Errorf("index %d: got (%q, %q), want (%q, %q)", i, original[i], compressed[i], wantOriginal, wantCompressed)
+		}
+	}
+}
+
+// TestResolveImageBatchPartialFailureReturnsOnlyFailedIndices verifies that
+// only the indices whose URL failed to resolve are reported back, so a
+// caller retrying the batch doesn't re-resolve images that already
+// succeeded.
+func TestResolveImageBatchPartialFailureReturnsOnlyFailedIndices(t *testing.T) {
+	urls := []string{
+		"http://example.com/ok.jpg",
+		"http://example.com/bad.jpg",
+		"http://example.com/ok.jpg",
+	}
+	indices := []int{0, 1, 2}
+	original := make([]string, len(urls))
+	compressed := make([]string, len(urls))
+
+	resolve := func(_ context.Context, url string) (string, string, error) {
+		if url == "http://example.com/bad.jpg" {
+			return "", "", fmt.Errorf("boom")
+		}
+		return url + "-original", url + "-compressed", nil
+	}
+
+	failed, err := resolveImageBatchWith(context.Background(), urls, indices, original, compressed, resolve)
+	if err == nil {
+		t.Fatal("expected an aggregated error")
+	}
+
+	sort.Ints(failed)
+	if len(failed) != 1 || failed[0] != 1 {
+		t.Fatalf("expected only index 1 to be reported as failed, got %v", failed)
+	}
+	if original[0] == "" || compressed[0] == "" || original[2] == "" || compressed[2] == "" {
+		t.Fatalf("expected the succeeding indices to be populated: original=%v compressed=%v", original, compressed)
+	}
+}
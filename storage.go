@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSignedURLExpiry is used when SIGNED_URL_EXPIRY_SECONDS is unset.
+const defaultSignedURLExpiry = 15 * time.Minute
+
+// Storage abstracts where image bytes live, so the pipeline doesn't care
+// whether it's running against local disk (single-node dev/test) or a
+// shared object store that any API/worker instance can reach. Products
+// store the opaque key Put returns; callers ask for a SignedURL when they
+// need to hand a client something fetchable.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+var storage Storage
+var signedURLExpiry = defaultSignedURLExpiry
+
+// setupStorage picks the storage backend from STORAGE_BACKEND ("local", the
+// default, or "s3") and applies SIGNED_URL_EXPIRY_SECONDS if set.
+func setupStorage() Storage {
+	if expirySeconds := os.Getenv("SIGNED_URL_EXPIRY_SECONDS"); expirySeconds != "" {
+		if secs, err := strconv.Atoi(expirySeconds); err == nil {
+			signedURLExpiry = time.Duration(secs) * time.Second
+		}
+	}
+
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "s3":
+		s3Storage, err := newS3Storage()
+		if err != nil {
+			logger.Fatalf("Failed to initialize S3 storage: %v", err)
+		}
+		storage = s3Storage
+	default:
+		storage = newLocalStorage(os.Getenv("LOCAL_STORAGE_DIR"))
+	}
+
+	return storage
+}
+
+// LocalStorage keeps blobs on the node's own filesystem. It has no real
+// signing capability, so SignedURL just builds a URL under
+// LOCAL_STORAGE_PUBLIC_URL; it only makes sense on a single-node deployment.
+type LocalStorage struct {
+	baseDir   string
+	publicURL string
+}
+
+func newLocalStorage(baseDir string) *LocalStorage {
+	if baseDir == "" {
+		baseDir = "."
+	}
+	return &LocalStorage{
+		baseDir:   baseDir,
+		publicURL: os.Getenv("LOCAL_STORAGE_PUBLIC_URL"),
+	}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	fullPath := filepath.Join(s.baseDir, key)
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	f, err := os.Create(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create local blob: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return "", fmt.Errorf("failed to write local blob: %w", err)
+	}
+
+	return key, nil
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.baseDir, key))
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.Join(s.baseDir, key))
+}
+
+func (s *LocalStorage) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return strings.TrimRight(s.publicURL, "/") + "/" + filepath.ToSlash(key), nil
+}
+
+// S3Storage stores blobs in an S3-compatible bucket and hands out
+// presigned GET URLs, so no single node needs to own the disk and the API
+// can run behind a load balancer.
+type S3Storage struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
+}
+
+func newS3Storage() (*S3Storage, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET is required for the s3 storage backend")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg)
+	return &S3Storage{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+		bucket:  bucket,
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload blob to s3: %w", err)
+	}
+	return key, nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blob from s3: %w", err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete blob from s3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign s3 url: %w", err)
+	}
+	return req.URL, nil
+}
+
+// signProductImages returns a copy of product with its image keys replaced
+// by signed URLs, for handlers to encode in their HTTP response. The
+// underlying keys (not signed URLs) are what's persisted and cached.
+func signProductImages(ctx context.Context, product Product) Product {
+	signed := product
+	signed.ProductImages = signKeys(ctx, product.ProductImages)
+	signed.CompressedProductImages = signKeys(ctx, product.CompressedProductImages)
+	return signed
+}
+
+func signProductsImages(ctx context.Context, products []Product) []Product {
+	signed := make([]Product, len(products))
+	for i, product := range products {
+		signed[i] = signProductImages(ctx, product)
+	}
+	return signed
+}
+
+func signKeys(ctx context.Context, keys []string) pq.StringArray {
+	urls := make(pq.StringArray, len(keys))
+	for i, key := range keys {
+		url, err := storage.SignedURL(ctx, key, signedURLExpiry)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"key":   key,
+				"error": err,
+			}).Warn("Failed to sign storage URL")
+			urls[i] = key
+			continue
+		}
+		urls[i] = url
+	}
+	return urls
+}
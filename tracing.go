@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// TracingConfig controls how spans are sampled and where they're exported;
+// it's populated from .env by setupTracing.
+type TracingConfig struct {
+	JaegerEndpoint string
+	ServiceName    string
+	SampleRate     float64
+}
+
+var tracer = otel.Tracer("zocket")
+
+// setupTracing reads TracingConfig from the environment, installs a Jaeger
+// exporter as the global TracerProvider, and returns a shutdown func that
+// flushes any spans still buffered. Callers should defer shutdown and also
+// invoke it from their signal handler so a killed process doesn't drop the
+// tail of a trace.
+func setupTracing() func(context.Context) error {
+	cfg := TracingConfig{
+		JaegerEndpoint: os.Getenv("JAEGER_ENDPOINT"),
+		ServiceName:    os.Getenv("TRACING_SERVICE_NAME"),
+		SampleRate:     1.0,
+	}
+	if cfg.JaegerEndpoint == "" {
+		cfg.JaegerEndpoint = "http://localhost:14268/api/traces"
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "zocket"
+	}
+	if rate := os.Getenv("TRACING_SAMPLE_RATE"); rate != "" {
+		if parsed, err := strconv.ParseFloat(rate, 64); err == nil {
+			cfg.SampleRate = parsed
+		}
+	}
+
+	exporter, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(cfg.JaegerEndpoint)))
+	if err != nil {
+		logger.Fatalf("Failed to create Jaeger exporter: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.SampleRate)),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(cfg.ServiceName),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	logger.WithFields(logrus.Fields{
+		"jaeger_endpoint": cfg.JaegerEndpoint,
+		"service_name":    cfg.ServiceName,
+		"sample_rate":     cfg.SampleRate,
+	}).Info("Tracing initialized")
+
+	return provider.Shutdown
+}
+
+// tracingMiddleware extracts an incoming trace context (if any) and starts
+// a server span for every request, so handler spans below it attach to the
+// caller's trace instead of starting a new one.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path)
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.url", r.URL.String()),
+		)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// dbSpan wraps a gorm call with a span recording how long the query took.
+func dbSpan(ctx context.Context, name string, fn func() error) error {
+	ctx, span := tracer.Start(ctx, "db."+name)
+	defer span.End()
+
+	start := time.Now()
+	err := fn()
+	span.SetAttributes(attribute.Int64("db.duration_ms", time.Since(start).Milliseconds()))
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// amqpHeaderCarrier adapts amqp.Table to otel's TextMapCarrier so trace
+// context can ride along in message headers across the RabbitMQ hop.
+type amqpHeaderCarrier map[string]interface{}
+
+func (c amqpHeaderCarrier) Get(key string) string {
+	if v, ok := c[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (c amqpHeaderCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c amqpHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func injectTraceHeaders(ctx context.Context) map[string]interface{} {
+	headers := amqpHeaderCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+	return headers
+}
+
+func extractTraceContext(ctx context.Context, headers map[string]interface{}) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, amqpHeaderCarrier(headers))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// maxUploadBytes and maxFileBytes bound the multipart upload endpoint and
+// can be overridden via MAX_UPLOAD_BYTES / MAX_FILE_BYTES in .env.
+var (
+	maxUploadBytes int64 = 50 << 20 // 50MB per request
+	maxFileBytes   int64 = 10 << 20 // 10MB per file
+)
+
+var allowedImageMimeTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// uploadProductImage accepts a multipart/form-data upload of one or more
+// "image" parts and appends the processed results to an existing product,
+// as an alternative to the URL-fetch flow the worker runs off a createProduct
+// job.
+func uploadProductImage(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, _ := strconv.Atoi(params["id"])
+
+	var product Product
+	if err := db.First(&product, id).Error; err != nil {
+		logger.WithFields(logrus.Fields{
+			"product_id": id,
+			"error":      err,
+		}).Error("Product not found")
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Error("Invalid multipart upload")
+		http.Error(w, "Invalid multipart upload", http.StatusBadRequest)
+		return
+	}
+
+	os.MkdirAll("images", os.ModePerm)
+
+	var newOriginalKeys, newCompressedKeys []string
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{"error": err}).Error("Failed to read multipart part")
+			http.Error(w, "Invalid multipart upload", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "image" && part.FormName() != "images" {
+			part.Close()
+			continue
+		}
+
+		originalKey, compressedKey, err := resolveUploadedImage(r.Context(), part)
+		part.Close()
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"product_id": id,
+				"error":      err,
+			}).Error("Failed to process uploaded image")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newOriginalKeys = append(newOriginalKeys, originalKey)
+		newCompressedKeys = append(newCompressedKeys, compressedKey)
+	}
+
+	product.ProductImages = append(product.ProductImages, newOriginalKeys...)
+	product.CompressedProductImages = append(product.CompressedProductImages, newCompressedKeys...)
+
+	if err := db.Model(&Product{}).Where("id = ?", product.ID).Updates(map[string]interface{}{
+		"product_images":            pq.StringArray(product.ProductImages),
+		"compressed_product_images": pq.StringArray(product.CompressedProductImages),
+	}).Error; err != nil {
+		logger.WithFields(logrus.Fields{
+			"product_id": id,
+			"error":      err,
+		}).Error("Failed to save uploaded images")
+		http.Error(w, "Failed to save uploaded images", http.StatusInternalServerError)
+		return
+	}
+	invalidateProductCache(product.ID)
+
+	json.NewEncoder(w).Encode(signProductImages(r.Context(), product))
+}
+
+// resolveUploadedImage sniffs the real content type from the part's first
+// 512 bytes, rejects anything that isn't an allowed image type, and streams
+// the remainder through an io.Pipe into both a local staging file and the
+// image decoder concurrently, so the raw file is never buffered in memory.
+// The decoded image is compressed afterwards, only when its digest is not
+// already stored. The result is stored content-addressed through Storage,
+// same as the URL-fetch path.
+func resolveUploadedImage(ctx context.Context, part io.Reader) (string, string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(part, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	header = header[:n]
+
+	mtype := mimetype.Detect(header)
+	if !allowedImageMimeTypes[mtype.String()] {
+		return "", "", fmt.Errorf("unsupported content type: %s", mtype.String())
+	}
+
+	limited := io.LimitReader(io.MultiReader(bytes.NewReader(header), part), maxFileBytes+1)
+
+	tempPath := filepath.Join("images", uuid.New().String()+".tmp")
+	outFile, err := os.Create(tempPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer os.Remove(tempPath)
+
+	pr, pw := io.Pipe()
+	hasher := sha256.New()
+	copyDone := make(chan error, 1)
+
+	go func() {
+		defer outFile.Close()
+		defer pw.Close()
+		_, copyErr := io.Copy(io.MultiWriter(outFile, hasher, pw), limited)
+		copyDone <- copyErr
+	}()
+
+	img, decodeErr := imaging.Decode(pr)
+	io.Copy(io.Discard, pr) // drain in case decode stopped early
+	copyErr := <-copyDone
+
+	if copyErr != nil {
+		return "", "", fmt.Errorf("failed to save upload: %w", copyErr)
+	}
+	if decodeErr != nil {
+		return "", "", fmt.Errorf("failed to decode image: %w", decodeErr)
+	}
+
+	fi, err := os.Stat(tempPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to stat upload: %w", err)
+	}
+	if fi.Size() > maxFileBytes {
+		return "", "", fmt.Errorf("file exceeds the %d byte limit", maxFileBytes)
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	extension := imageExtension(mtype.String(), "")
+	originalKey := filepath.Join("images", digest+extension)
+	compressedKey := filepath.Join("compressed_images", digest+extension)
+
+	return resolveImageBlob(digest, fi.Size(), mtype.String(), func() (string, string, error) {
+		staged, err := os.Open(tempPath)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to reopen staged upload: %w", err)
+		}
+		_, err = storage.Put(ctx, originalKey, staged, mtype.String())
+		staged.Close()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to store uploaded image: %w", err)
+		}
+
+		compressed, compressedContentType, err := compressImage(ctx, img, extension)
+		if err != nil {
+			return "", "", err
+		}
+
+		if _, err := storage.Put(ctx, compressedKey, compressed, compressedContentType); err != nil {
+			return "", "", fmt.Errorf("failed to store compressed image: %w", err)
+		}
+
+		return originalKey, compressedKey, nil
+	})
+}
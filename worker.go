@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const maxJobRetries = 5
+
+// publishImageJob enqueues an ImageJob onto imageJobsQueue for a worker
+// process to pick up. The caller's trace context rides along in the
+// message headers so the worker's spans attach to the request that
+// triggered the job.
+func publishImageJob(ctx context.Context, conn *amqp.Connection, job ImageJob) error {
+	ctx, span := tracer.Start(ctx, "publishImageJob")
+	defer span.End()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(imageJobsQueue, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return ch.Publish("", imageJobsQueue, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Headers:      amqp.Table(injectTraceHeaders(ctx)),
+		Body:         body,
+	})
+}
+
+// runWorker consumes ImageJobs from imageJobsQueue and processes them until
+// ctx is cancelled (on SIGTERM/SIGINT, see main) or the connection closes on
+// its own. It blocks until every in-flight job has finished, so the caller
+// can flush traces immediately after it returns without losing worker-side
+// spans.
+func runWorker(ctx context.Context, conn *amqp.Connection, concurrency int) {
+	ch, err := conn.Channel()
+	if err != nil {
+		logger.Fatalf("Failed to open channel: %v", err)
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(imageJobsQueue, true, false, false, false, nil); err != nil {
+		logger.Fatalf("Failed to declare queue: %v", err)
+	}
+
+	if err := ch.Qos(concurrency, 0, false); err != nil {
+		logger.Fatalf("Failed to set QoS: %v", err)
+	}
+
+	msgs, err := ch.Consume(imageJobsQueue, "", false, false, false, false, nil)
+	if err != nil {
+		logger.Fatalf("Failed to register consumer: %v", err)
+	}
+
+	logger.WithFields(logrus.Fields{"concurrency": concurrency}).Info("Worker listening for image jobs")
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+consume:
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Worker shutting down, waiting for in-flight jobs to finish")
+			break consume
+		case msg, ok := <-msgs:
+			if !ok {
+				break consume
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				handleImageJob(msg)
+			}()
+		}
+	}
+
+	wg.Wait()
+}
+
+func handleImageJob(msg amqp.Delivery) {
+	var job ImageJob
+	if err := json.Unmarshal(msg.Body, &job); err != nil {
+		logger.WithFields(logrus.Fields{"error": err}).Error("Failed to decode image job")
+		msg.Nack(false, false)
+		return
+	}
+
+	ctx := extractTraceContext(context.Background(), msg.Headers)
+	ctx, span := tracer.Start(ctx, "handleImageJob")
+	defer span.End()
+
+	log := logger.WithFields(logrus.Fields{"product_id": job.ProductID})
+
+	if err := db.Model(&Product{}).Where("id = ?", job.ProductID).
+		Update("processing_status", StatusProcessing).Error; err != nil {
+		log.WithFields(logrus.Fields{"error": err}).Error("Failed to mark product as processing")
+	}
+
+	originalPaths := make([]string, len(job.ImageURLs))
+	compressedPaths := make([]string, len(job.ImageURLs))
+
+	onProgress := func(originalPaths, compressedPaths []string) {
+		if err := persistImagePaths(job.ProductID, originalPaths, compressedPaths); err != nil {
+			log.WithFields(logrus.Fields{"error": err}).Warn("Failed to persist in-progress image paths")
+		}
+		invalidateProductCache(job.ProductID)
+	}
+
+	processErr := retryImageBatch(ctx, job.ImageURLs, originalPaths, compressedPaths, log, resolveImageBatch, time.Sleep, onProgress)
+
+	// Whatever succeeded is persisted by onProgress as each attempt
+	// completes, so the images that made it through aren't lost just
+	// because a sibling image exhausted its retries.
+	if processErr != nil {
+		log.WithFields(logrus.Fields{"error": processErr}).Error("Image processing failed after retries")
+		db.Model(&Product{}).Where("id = ?", job.ProductID).Updates(map[string]interface{}{
+			"processing_status": StatusFailed,
+			"processing_error":  processErr.Error(),
+		})
+		invalidateProductCache(job.ProductID)
+		msg.Ack(false)
+		return
+	}
+
+	if err := db.Model(&Product{}).Where("id = ?", job.ProductID).
+		Update("processing_status", StatusDone).Error; err != nil {
+		log.WithFields(logrus.Fields{"error": err}).Error("Failed to mark product as done")
+	} else {
+		log.Info("Product images processed successfully")
+	}
+	invalidateProductCache(job.ProductID)
+
+	msg.Ack(false)
+}
+
+// persistImagePaths writes the current (possibly partial) originalPaths/
+// compressedPaths onto the product row. It's called after every processing
+// attempt, including the last, so a reader polling GET /products/{id}/status
+// sees images_done rise between retry attempts, and the images already
+// processed are not lost if the job ultimately fails. Images finishing
+// within a single attempt are not persisted until that attempt completes.
+func persistImagePaths(productID uint, originalPaths, compressedPaths []string) error {
+	return db.Model(&Product{}).Where("id = ?", productID).Updates(map[string]interface{}{
+		"product_images":            pq.StringArray(originalPaths),
+		"compressed_product_images": pq.StringArray(compressedPaths),
+	}).Error
+}
+
+// retryImageBatch resolves imageUrls via resolveBatch, retrying only the
+// images still pending after each attempt with exponential backoff, up to
+// maxJobRetries. An image that already succeeded isn't re-resolved (and its
+// blob's refcount isn't bumped again) just because a sibling image in the
+// same job failed. onProgress, if non-nil, is called with the current
+// originalPaths/compressedPaths after every attempt (successful or not) so
+// a caller can persist partial progress as it happens rather than only
+// once the whole batch settles. resolveBatch, sleep, and onProgress are
+// injected so the retry/backoff bookkeeping can be unit tested without a
+// real download, database, or wall-clock wait.
+func retryImageBatch(ctx context.Context, imageUrls []string, originalPaths, compressedPaths []string, log *logrus.Entry, resolveBatch func(context.Context, []string, []int, []string, []string) ([]int, error), sleep func(time.Duration), onProgress func(originalPaths, compressedPaths []string)) error {
+	pending := make([]int, len(imageUrls))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	var processErr error
+
+	for attempt := 0; attempt < maxJobRetries && len(pending) > 0; attempt++ {
+		pending, processErr = resolveBatch(ctx, imageUrls, pending, originalPaths, compressedPaths)
+		if onProgress != nil {
+			onProgress(originalPaths, compressedPaths)
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		if attempt == maxJobRetries-1 {
+			break
+		}
+
+		backoff := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+		log.WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"pending": len(pending),
+			"error":   processErr,
+			"backoff": backoff,
+		}).Warn("Image processing attempt failed, retrying")
+		sleep(backoff)
+	}
+
+	return processErr
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestRetryImageBatchStopsOnceAllImagesSucceed verifies that retryImageBatch
+// retries only the images still pending and stops as soon as none remain,
+// without sleeping or making further resolve attempts.
+func TestRetryImageBatchStopsOnceAllImagesSucceed(t *testing.T) {
+	imageUrls := []string{"a", "b", "c"}
+	original := make([]string, len(imageUrls))
+	compressed := make([]string, len(imageUrls))
+
+	var attempts int
+	var pendingByAttempt [][]int
+	resolveBatch := func(_ context.Context, _ []string, pending []int, originalPaths, compressedPaths []string) ([]int, error) {
+		attempts++
+		pendingByAttempt = append(pendingByAttempt, append([]int(nil), pending...))
+
+		// Index 1 ("b") only succeeds on the second attempt; everything else
+		// succeeds immediately.
+		var failed []int
+		for _, i := range pending {
+			if i == 1 && attempts == 1 {
+				failed = append(failed, i)
+				continue
+			}
+			originalPaths[i] = fmt.Sprintf("original-%d", i)
+			compressedPaths[i] = fmt.Sprintf("compressed-%d", i)
+		}
+		if len(failed) > 0 {
+			return failed, fmt.Errorf("image %v failed", failed)
+		}
+		return nil, nil
+	}
+
+	var slept []time.Duration
+	sleep := func(d time.Duration) { slept = append(slept, d) }
+
+	var progressCalls int
+	onProgress := func(_, _ []string) { progressCalls++ }
+
+	log := logrus.NewEntry(logrus.New())
+	err := retryImageBatch(context.Background(), imageUrls, original, compressed, log, resolveBatch, sleep, onProgress)
+	if err != nil {
+		t.Fatalf("expected eventual success, got error: %v", err)
+	}
+	if progressCalls != attempts {
+		t.Fatalf("expected onProgress to be called once per attempt (%d), got %d", attempts, progressCalls)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected exactly 2 attempts, got %d", attempts)
+	}
+	if len(slept) != 1 {
+		t.Fatalf("expected exactly one backoff sleep, got %d", len(slept))
+	}
+	if got := pendingByAttempt[1]; len(got) != 1 || got[0] != 1 {
+		t.Fatalf("expected only index 1 to be retried on the second attempt, got %v", got)
+	}
+	for i := range imageUrls {
+		if original[i] == "" || compressed[i] == "" {
+			t.Errorf("index %d was never resolved", i)
+		}
+	}
+}
+
+// TestRetryImageBatchGivesUpAfterMaxRetries verifies that retryImageBatch
+// stops after maxJobRetries attempts and surfaces the last error instead of
+// retrying forever.
+func TestRetryImageBatchGivesUpAfterMaxRetries(t *testing.T) {
+	imageUrls := []string{"a"}
+	original := make([]string, len(imageUrls))
+	compressed := make([]string, len(imageUrls))
+
+	var attempts int
+	resolveBatch := func(_ context.Context, _ []string, pending []int, _, _ []string) ([]int, error) {
+		attempts++
+		return pending, fmt.Errorf("always fails")
+	}
+
+	log := logrus.NewEntry(logrus.New())
+	err := retryImageBatch(context.Background(), imageUrls, original, compressed, log, resolveBatch, func(time.Duration) {}, nil)
+	if err == nil {
+		t.Fatal("expected an error after exhausting retries")
+	}
+	if attempts != maxJobRetries {
+		t.Fatalf("expected %d attempts, got %d", maxJobRetries, attempts)
+	}
+}
+
+// TestRetryImageBatchReportsProgressOnPermanentFailure verifies that the
+// images which did succeed are visible to onProgress even when the batch as
+// a whole ultimately fails, so a caller persisting on each callback doesn't
+// lose that partial work.
+func TestRetryImageBatchReportsProgressOnPermanentFailure(t *testing.T) {
+	imageUrls := []string{"ok", "bad"}
+	original := make([]string, len(imageUrls))
+	compressed := make([]string, len(imageUrls))
+
+	resolveBatch := func(_ context.Context, urls []string, pending []int, originalPaths, compressedPaths []string) ([]int, error) {
+		var failed []int
+		for _, i := range pending {
+			if urls[i] == "bad" {
+				failed = append(failed, i)
+				continue
+			}
+			originalPaths[i] = "original-" + urls[i]
+			compressedPaths[i] = "compressed-" + urls[i]
+		}
+		return failed, fmt.Errorf("image %v failed", failed)
+	}
+
+	var lastOriginal, lastCompressed []string
+	onProgress := func(originalPaths, compressedPaths []string) {
+		lastOriginal = append([]string(nil), originalPaths...)
+		lastCompressed = append([]string(nil), compressedPaths...)
+	}
+
+	log := logrus.NewEntry(logrus.New())
+	err := retryImageBatch(context.Background(), imageUrls, original, compressed, log, resolveBatch, func(time.Duration) {}, onProgress)
+	if err == nil {
+		t.Fatal("expected an error since \"bad\" never succeeds")
+	}
+	if lastOriginal[0] != "original-ok" || lastCompressed[0] != "compressed-ok" {
+		t.Fatalf("expected the successful image's result to survive in the last progress snapshot, got original=%v compressed=%v", lastOriginal, lastCompressed)
+	}
+	if lastOriginal[1] != "" || lastCompressed[1] != "" {
+		t.Fatalf("expected the permanently failed image to remain unset, got original=%v compressed=%v", lastOriginal, lastCompressed)
+	}
+}